@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,21 +11,39 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/sync/errgroup"
 )
 
 /*
 A tool to preview changes before checkin.
-Uses WinMerge to do the diffing (http://winmerge.org/)
-Anohter option that wouldn't require winmerge is to make it a web server,
-implement web-based ui and launch the browser.
+Diffing is done with an external diff tool (WinMerge, Meld, kdiff3, Beyond
+Compare, VS Code, or whatever `git difftool` would launch); see DiffTool
+in difftool.go. Pass --web to skip the external tool and view the diff in a
+browser instead; see webui.go.
 */
 
 var (
-	gitPath      string
-	winMergePath string
-	tempDir      string
+	gitPath string
+	tempDir string
+	// gitDirPath is the resolved ".git" directory for the current repo,
+	// set by cdToGitRoot; it may live outside the worktree (linked worktrees,
+	// submodules) when ".git" was a gitdir-pointer file rather than a dir.
+	gitDirPath  string
+	backend     gitBackend
+	backendFlag = flag.String("backend", "exec", "git backend to use: 'exec' (shell out to git) or 'go-git' (native)")
+	toolFlag    = flag.String("tool", "", "diff tool to use (winmerge, meld, kdiff3, bcompare, code, or a git difftool name); default: SUMATRA_DIFFTOOL env, git config diff.tool, then first one found on PATH")
+	stagedFlag  = flag.Bool("staged", false, "preview only staged changes (like `git diff --cached`)")
+	rangeFlag   = flag.String("range", "", "preview the diff between two revisions, e.g. --range main..feature")
+	commitFlag  = flag.String("commit", "", "preview a single commit, e.g. --commit HEAD~3")
+	webFlag     = flag.Bool("web", false, "show the diff in a browser instead of launching an external diff tool")
 )
 
 const (
@@ -36,6 +57,320 @@ type GitChange struct {
 	Type int // Modified, Added etc.
 	Path string
 	Name string
+
+	// OldPath is the pre-rename/copy path, set when Renamed or Copied is true.
+	OldPath string
+	// IndexStatus and WorktreeStatus are the raw porcelain v1 XY status
+	// codes (e.g. 'M', 'A', 'D', 'R', 'C', 'U', '?', ' ').
+	IndexStatus, WorktreeStatus byte
+	Renamed, Copied, Unmerged   bool
+}
+
+// gitBackend abstracts the way we talk to git so the tool can run either by
+// shelling out to the git binary (execBackend, today's behavior) or natively
+// via go-git (goGitBackend), which needs no git executable on PATH and works
+// uniformly against bare repos and packed refs.
+type gitBackend interface {
+	Name() string
+	Status() []*GitChange
+	// FileAt returns the content of path at rev; rev == "" means the index
+	// (git's ":path" stage-0 form), letting --staged reuse the same call.
+	FileAt(rev, path string) []byte
+	// DiffTree returns the changes between two revisions/branches, e.g. for
+	// --range and --commit.
+	DiffTree(from, to string) []*GitChange
+}
+
+// newGitBackend picks the backend implementation named by --backend. gitDir
+// is the ".git" directory resolved by cdToGitRoot (resolveGitDirMust),
+// passed through so newGoGitBackend can resolve ".git" for dir again with
+// resolveGitDirMust and fail if the result differs from gitDir. Inside a
+// linked worktree ".git" is a "gitdir: <path>" pointer file rather than a
+// directory, and gitDir is the directory that pointer names.
+func newGitBackend(kind, dir, gitDir string) gitBackend {
+	switch kind {
+	case "exec":
+		return &execBackend{}
+	case "go-git":
+		return newGoGitBackend(dir, gitDir)
+	default:
+		fatalf("unknown --backend '%s' (want 'exec' or 'go-git')\n", kind)
+		return nil
+	}
+}
+
+// execBackend shells out to the git binary, same as before gitBackend existed.
+type execBackend struct{}
+
+func (b *execBackend) Name() string { return "exec" }
+
+func (b *execBackend) Status() []*GitChange {
+	out, err := runCmd(gitPath, "status", "--porcelain")
+	fataliferr(err)
+	return parseGitStatusMust(out, false)
+}
+
+func (b *execBackend) FileAt(rev, path string) []byte {
+	out, err := runCmd(gitPath, "show", rev+":"+path)
+	fataliferr(err)
+	return out
+}
+
+func (b *execBackend) DiffTree(from, to string) []*GitChange {
+	var args []string
+	if to == indexRev {
+		// --staged: diff the index against from (normally HEAD)
+		args = []string{"diff", "--cached", "--name-status"}
+		if from != "" && from != "HEAD" {
+			args = append(args, from)
+		}
+	} else {
+		args = []string{"diff", "--name-status", from, to}
+	}
+	out, err := runCmd(gitPath, args...)
+	fataliferr(err)
+	return parseNameStatusMust(out)
+}
+
+// goGitBackend talks to the repo natively via go-git, so it works in
+// containers without a git binary and against bare repos/packed refs.
+type goGitBackend struct {
+	repo *git.Repository
+}
+
+func newGoGitBackend(dir, gitDir string) *goGitBackend {
+	// EnableDotGitCommonDir makes go-git follow the linked worktree's
+	// "commondir" file back to the main repo's objects/refs; without it,
+	// PlainOpen only sees the worktree-private ".git/worktrees/<name>" dir
+	// and fails to resolve anything a plain "git show" would find fine.
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{
+		EnableDotGitCommonDir: true,
+	})
+	fataliferr(err)
+	if resolved, ok := resolveGitDirMust(dir); !ok || resolved != gitDir {
+		fatalf("go-git backend resolved a different .git than cdToGitRoot did: %q vs %q\n", resolved, gitDir)
+	}
+	return &goGitBackend{repo: repo}
+}
+
+func (b *goGitBackend) Name() string { return "go-git" }
+
+func (b *goGitBackend) Status() []*GitChange {
+	wt, err := b.repo.Worktree()
+	fataliferr(err)
+	ignoreMatcher := loadGitignoreMatcherMust(wt)
+	st, err := wt.Status()
+	fataliferr(err)
+	var res []*GitChange
+	for path, fs := range st {
+		c := &GitChange{Path: path, Name: filepath.Base(path)}
+		switch {
+		case fs.Worktree == git.Untracked:
+			// .gitignore only ever applies to untracked paths -- a tracked
+			// file that happens to match a pattern (e.g. force-added) still
+			// has its modifications shown, same as `git status`.
+			if ignoreMatcher != nil && ignoreMatcher.Match(strings.Split(path, "/"), false) {
+				continue
+			}
+			c.Type = NotCheckedIn
+		case fs.Worktree == git.Deleted || fs.Staging == git.Deleted:
+			c.Type = Deleted
+		case fs.Worktree == git.Added || fs.Staging == git.Added:
+			c.Type = Added
+		default:
+			c.Type = Modified
+		}
+		if c.Type == NotCheckedIn {
+			continue
+		}
+		res = append(res, c)
+	}
+	return b.detectRenamesMust(res, func(c *GitChange) []byte {
+		d, err := ioutil.ReadFile(c.Path)
+		if err != nil {
+			return nil
+		}
+		return d
+	})
+}
+
+// detectRenamesMust pairs up Deleted/Added changes with byte-identical
+// content into a single Renamed change, the way git's own -M similarity
+// detection would for a pure rename. This exists because go-git's
+// Worktree.Status never does it itself: Renamed/Copied/UpdatedButUnmerged
+// are declared as StatusCode constants but nothing in go-git's real
+// status-computation code ever assigns them, so without this pass every
+// rename under --backend go-git would show up as an unrelated delete of
+// the old path plus an add of the new one. addedContent fetches the
+// candidate "after" content for an Added change (the worktree file for
+// Status, the index blob for stagedChangesMust).
+//
+// Copies are deliberately left undetected: finding a copy's source means
+// hashing the entire HEAD tree against every Added file, which is the same
+// cost git itself only pays under --find-copies. An undetected copy just
+// falls back to a plain Added entry, which copyFileChangeMust handles
+// safely (it diffs against an empty "before", same as any other new file).
+func (b *goGitBackend) detectRenamesMust(changes []*GitChange, addedContent func(*GitChange) []byte) []*GitChange {
+	var deleted, added, rest []*GitChange
+	for _, c := range changes {
+		switch c.Type {
+		case Deleted:
+			deleted = append(deleted, c)
+		case Added:
+			added = append(added, c)
+		default:
+			rest = append(rest, c)
+		}
+	}
+	usedAdded := make(map[int]bool)
+	for _, d := range deleted {
+		before := b.blobAtHeadOrNil(d.Path)
+		if before == nil {
+			rest = append(rest, d)
+			continue
+		}
+		matched := -1
+		for i, a := range added {
+			if usedAdded[i] {
+				continue
+			}
+			if bytes.Equal(before, addedContent(a)) {
+				matched = i
+				break
+			}
+		}
+		if matched == -1 {
+			rest = append(rest, d)
+			continue
+		}
+		usedAdded[matched] = true
+		added[matched].Renamed = true
+		added[matched].OldPath = d.Path
+	}
+	return append(rest, added...)
+}
+
+// blobAtHeadOrNil returns path's content at HEAD, or nil if it isn't
+// tracked there (e.g. added and deleted again before ever landing in a
+// commit).
+func (b *goGitBackend) blobAtHeadOrNil(path string) []byte {
+	tree := b.treeAtMust("HEAD")
+	file, err := tree.File(path)
+	if err != nil {
+		return nil
+	}
+	content, err := file.Contents()
+	fataliferr(err)
+	return []byte(content)
+}
+
+func (b *goGitBackend) FileAt(rev, path string) []byte {
+	if rev == "" {
+		return b.fileInIndexMust(path)
+	}
+	tree := b.treeAtMust(rev)
+	file, err := tree.File(path)
+	fataliferr(err)
+	content, err := file.Contents()
+	fataliferr(err)
+	return []byte(content)
+}
+
+func (b *goGitBackend) fileInIndexMust(path string) []byte {
+	idx, err := b.repo.Storer.Index()
+	fataliferr(err)
+	entry, err := idx.Entry(path)
+	fataliferr(err)
+	blob, err := b.repo.BlobObject(entry.Hash)
+	fataliferr(err)
+	reader, err := blob.Reader()
+	fataliferr(err)
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	fataliferr(err)
+	return content
+}
+
+func (b *goGitBackend) treeAtMust(rev string) *object.Tree {
+	hash, err := b.repo.ResolveRevision(plumbing.Revision(rev))
+	fataliferr(err)
+	commit, err := b.repo.CommitObject(*hash)
+	fataliferr(err)
+	tree, err := commit.Tree()
+	fataliferr(err)
+	return tree
+}
+
+func (b *goGitBackend) DiffTree(from, to string) []*GitChange {
+	if to == indexRev {
+		return b.stagedChangesMust()
+	}
+	fromTree := b.treeAtMust(from)
+	toTree := b.treeAtMust(to)
+	changes, err := fromTree.Diff(toTree)
+	fataliferr(err)
+	var res []*GitChange
+	for _, ch := range changes {
+		c := &GitChange{}
+		switch {
+		case ch.From.Name == "":
+			c.Type = Added
+			c.Path = ch.To.Name
+		case ch.To.Name == "":
+			c.Type = Deleted
+			c.Path = ch.From.Name
+		default:
+			c.Type = Modified
+			c.Path = ch.To.Name
+		}
+		c.Name = filepath.Base(c.Path)
+		res = append(res, c)
+	}
+	return res
+}
+
+// stagedChangesMust returns only the entries staged in the index, for
+// --staged. Like Status, it runs a rename-pairing pass (see
+// detectRenamesMust) since go-git never sets Renamed/Copied itself; merge
+// conflicts (Unmerged) aren't detected either way, because go-git's
+// Worktree.Status has no notion of the index's stage-1/2/3 entries during
+// an unresolved merge (see gitShowStageMust's doc comment) — an unmerged
+// path just falls through to Modified here, same as before this pass.
+func (b *goGitBackend) stagedChangesMust() []*GitChange {
+	wt, err := b.repo.Worktree()
+	fataliferr(err)
+	st, err := wt.Status()
+	fataliferr(err)
+	var res []*GitChange
+	for path, fs := range st {
+		if fs.Staging == git.Unmodified || fs.Staging == git.Untracked {
+			continue
+		}
+		c := &GitChange{Path: path, Name: filepath.Base(path), IndexStatus: byte(fs.Staging)}
+		switch fs.Staging {
+		case git.Added:
+			c.Type = Added
+		case git.Deleted:
+			c.Type = Deleted
+		default:
+			c.Type = Modified
+		}
+		res = append(res, c)
+	}
+	return b.detectRenamesMust(res, func(c *GitChange) []byte {
+		return b.fileInIndexMust(c.Path)
+	})
+}
+
+// loadGitignoreMatcherMust loads .gitignore patterns for the worktree so
+// goGitBackend can filter out ignored files when enumerating untracked ones.
+func loadGitignoreMatcherMust(wt *git.Worktree) gitignore.Matcher {
+	patterns, err := gitignore.ReadPatterns(wt.Filesystem, nil)
+	fataliferr(err)
+	if len(patterns) == 0 {
+		return nil
+	}
+	return gitignore.NewMatcher(patterns)
 }
 
 func printStack() {
@@ -64,12 +399,15 @@ func fataliferr(err error) {
 	}
 }
 
-func toTrimmedLines(d []byte) []string {
+// toStatusLines splits `git status --porcelain` output into lines, trimming
+// only the trailing line ending. Unlike a plain TrimSpace, this preserves a
+// leading space in the XY status field, which is significant (e.g. " M"
+// means "modified in worktree, unmodified in index").
+func toStatusLines(d []byte) []string {
 	lines := strings.Split(string(d), "\n")
 	i := 0
 	for _, l := range lines {
-		l = strings.TrimSpace(l)
-		// remove empty lines
+		l = strings.TrimRight(l, "\r")
 		if len(l) > 0 {
 			lines[i] = l
 			i++
@@ -91,8 +429,10 @@ func detectExeMust(name string) string {
 }
 
 func detectExesMust() {
-	gitPath = detectExeMust("git")
-	winMergePath = detectExeMust("WinMergeU")
+	// the go-git backend needs no git executable on PATH
+	if *backendFlag == "exec" {
+		gitPath = detectExeMust("git")
+	}
 }
 
 func getWinTempDirMust() string {
@@ -119,30 +459,70 @@ func runCmd(exePath string, args ...string) ([]byte, error) {
 	return cmd.Output()
 }
 
+// unmergedPairs are the XY combinations porcelain v1 uses for a conflicted
+// file: one code per side (us/them), 'U' meaning "updated but unmerged".
+var unmergedPairs = map[string]bool{
+	"UU": true, "AA": true, "DD": true,
+	"AU": true, "UA": true, "DU": true, "UD": true,
+}
+
+// unquoteGitPath undoes the C-style quoting git applies to a path containing
+// characters core.quotePath treats as "unusual" (see git-config(1)).
+func unquoteGitPath(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		unquoted, err := strconv.Unquote(s)
+		fataliferr(err)
+		return unquoted
+	}
+	return s
+}
+
+// parseGitStatusLineMust parses one line of `git status --porcelain` (v1):
+// a fixed two-character XY status field, a space, then the path - or, for
+// renames/copies, "oldpath -> newpath".
 func parseGitStatusLineMust(s string) *GitChange {
-	c := &GitChange{}
-	parts := strings.SplitN(s, " ", 2)
-	fatalif(len(parts) != 2, "invalid line: '%s'\n", s)
-	switch parts[0] {
-	case "M":
+	fatalif(len(s) < 4 || s[2] != ' ', "invalid status line: '%s'\n", s)
+	x, y := s[0], s[1]
+	rest := s[3:]
+
+	c := &GitChange{IndexStatus: x, WorktreeStatus: y}
+	switch {
+	case x == '?' && y == '?':
+		c.Type = NotCheckedIn
+	case unmergedPairs[string([]byte{x, y})]:
+		c.Unmerged = true
+		c.Type = Modified
+	case x == 'R' || y == 'R':
+		c.Renamed = true
 		c.Type = Modified
-	case "A":
+	case x == 'C' || y == 'C':
+		c.Copied = true
+		c.Type = Modified
+	case x == 'A' || y == 'A':
 		c.Type = Added
-	case "D":
+	case x == 'D' || y == 'D':
 		c.Type = Deleted
-	case "??":
-		c.Type = NotCheckedIn
+	case x == 'M' || y == 'M':
+		c.Type = Modified
 	default:
-		fatalif(true, "invalid line: '%s'\n", s)
+		fatalif(true, "invalid status line (unknown XY '%c%c'): '%s'\n", x, y, s)
+	}
+
+	if c.Renamed || c.Copied {
+		parts := strings.SplitN(rest, " -> ", 2)
+		fatalif(len(parts) != 2, "invalid rename/copy status line: '%s'\n", s)
+		c.OldPath = unquoteGitPath(parts[0])
+		c.Path = unquoteGitPath(parts[1])
+	} else {
+		c.Path = unquoteGitPath(rest)
 	}
-	c.Path = strings.TrimSpace(parts[1])
 	c.Name = filepath.Base(c.Path)
 	return c
 }
 
 func parseGitStatusMust(out []byte, includeNotCheckedIn bool) []*GitChange {
 	var res []*GitChange
-	lines := toTrimmedLines(out)
+	lines := toStatusLines(out)
 	for _, l := range lines {
 		c := parseGitStatusLineMust(l)
 		if !includeNotCheckedIn && c.Type == NotCheckedIn {
@@ -153,17 +533,65 @@ func parseGitStatusMust(out []byte, includeNotCheckedIn bool) []*GitChange {
 	return res
 }
 
+// parseNameStatusLineMust parses one line of `git diff --name-status`: a
+// status letter (a similarity score follows R/C, which we ignore), a tab,
+// the path, and for renames/copies a further tab and the new path.
+func parseNameStatusLineMust(s string) *GitChange {
+	parts := strings.Split(s, "\t")
+	fatalif(len(parts) < 2, "invalid name-status line: '%s'\n", s)
+	status := parts[0]
+	c := &GitChange{IndexStatus: status[0]}
+	switch status[0] {
+	case 'A':
+		c.Type = Added
+	case 'D':
+		c.Type = Deleted
+	case 'M':
+		c.Type = Modified
+	case 'R':
+		c.Renamed = true
+		c.Type = Modified
+	case 'C':
+		c.Copied = true
+		c.Type = Modified
+	default:
+		fatalif(true, "invalid name-status line (unknown status '%s'): '%s'\n", status, s)
+	}
+	if c.Renamed || c.Copied {
+		fatalif(len(parts) != 3, "invalid rename/copy name-status line: '%s'\n", s)
+		c.OldPath = parts[1]
+		c.Path = parts[2]
+	} else {
+		c.Path = parts[1]
+	}
+	c.Name = filepath.Base(c.Path)
+	return c
+}
+
+func parseNameStatusMust(out []byte) []*GitChange {
+	var res []*GitChange
+	for _, l := range toStatusLines(out) {
+		res = append(res, parseNameStatusLineMust(l))
+	}
+	return res
+}
+
 func gitStatusMust() []*GitChange {
-	out, err := runCmd(gitPath, "status", "--porcelain")
-	fataliferr(err)
-	return parseGitStatusMust(out, false)
+	return backend.Status()
+}
+
+// gitDiffTreeMust returns the changes between two revisions/branches, e.g.
+// "main" and "feature" for --range, used to give parity with `git difftool -d`.
+func gitDiffTreeMust(from, to string) []*GitChange {
+	return backend.DiffTree(from, to)
+}
+
+func gitGetFileContentAtMust(rev, path string) []byte {
+	return backend.FileAt(rev, path)
 }
 
 func gitGetFileContentHeadMust(path string) []byte {
-	loc := "HEAD:" + path
-	out, err := runCmd(gitPath, "show", loc)
-	fataliferr(err)
-	return out
+	return gitGetFileContentAtMust("HEAD", path)
 }
 
 // delete directories older than 1 day in tempDir
@@ -193,48 +621,51 @@ func getBeforeAfterDirs(dir string) (string, string) {
 	return dirBefore, dirAfter
 }
 
-// http://manual.winmerge.org/Command_line.html
-func runWinMerge(dir string) {
-	dirBefore, dirAfter := getBeforeAfterDirs(dir)
-	/*
-		/e : close with Esc
-		/u : don't add paths to MRU
-		/wl, wr : open left/right as read-only
-		/r : recursive compare
-	*/
-	_, err := runCmd(winMergePath, "/u", "/wl", "/wr", dirBefore, dirAfter)
+// diffFromRev/diffToRev configure where "before"/"after" content comes from.
+// The default (both empty) is the classic worktree-vs-HEAD comparison:
+// before is HEAD, after is the live file on disk. --staged sets diffToRev to
+// the sentinel indexRev (the index); --range and --commit set both to the
+// revisions being compared.
+const indexRev = ":" // git's own stage-0 notation, reused as our sentinel
+
+var diffFromRev, diffToRev string
+
+// writeFileMust writes content to dst atomically (write to a .tmp sibling,
+// then rename) so a Ctrl-C mid-copy never leaves the diff tool looking at a
+// half-written file.
+func writeFileMust(dst string, content []byte) {
+	tmp := dst + ".tmp"
+	f, err := os.Create(tmp)
 	fataliferr(err)
-}
-
-func catGitHeadToFileMust(dst, gitPath string) {
-	fmt.Printf("catGitHeadToFileMust: %s => %s\n", gitPath, dst)
-	d := gitGetFileContentHeadMust(gitPath)
-	f, err := os.Create(dst)
-	fataliferr(err)
-	defer f.Close()
-	_, err = f.Write(d)
+	_, err = f.Write(content)
 	fataliferr(err)
+	fataliferr(f.Close())
+	fataliferr(os.Rename(tmp, dst))
 }
 
-func createEmptyFileMust(path string) {
-	f, err := os.Create(path)
-	fataliferr(err)
-	f.Close()
+func getBeforeContentMust(path string) []byte {
+	rev := "HEAD"
+	if diffFromRev != "" {
+		rev = diffFromRev
+	}
+	return gitGetFileContentAtMust(rev, path)
 }
 
-func copyFileMust(dst, src string) {
-	// ensure windows-style dir separator
-	dst = strings.Replace(dst, "/", "\\", -1)
-	src = strings.Replace(src, "/", "\\", -1)
+func getAfterContentMust(path string) []byte {
+	switch diffToRev {
+	case "":
+		d, err := ioutil.ReadFile(path)
+		fataliferr(err)
+		return d
+	case indexRev:
+		return gitGetFileContentAtMust("", path)
+	default:
+		return gitGetFileContentAtMust(diffToRev, path)
+	}
+}
 
-	fdst, err := os.Create(dst)
-	fataliferr(err)
-	defer fdst.Close()
-	fsrc, err := os.Open(src)
-	fataliferr(err)
-	defer fsrc.Close()
-	_, err = io.Copy(fdst, fsrc)
-	fataliferr(err)
+func createEmptyFileMust(path string) {
+	writeFileMust(path, nil)
 }
 
 func copyFileAddedMust(dirBefore, dirAfter string, change *GitChange) {
@@ -242,61 +673,197 @@ func copyFileAddedMust(dirBefore, dirAfter string, change *GitChange) {
 	path := filepath.Join(dirBefore, change.Name)
 	createEmptyFileMust(path)
 	path = filepath.Join(dirAfter, change.Name)
-	copyFileMust(path, change.Path)
+	writeFileMust(path, getAfterContentMust(change.Path))
 }
 
 func copyFileDeletedMust(dirBefore, dirAfter string, change *GitChange) {
 	// empty file in after
 	path := filepath.Join(dirAfter, change.Name)
 	createEmptyFileMust(path)
-	// version from HEAD in before
+	// version from before-rev in before
 	path = filepath.Join(dirBefore, change.Name)
-	catGitHeadToFileMust(path, change.Path)
+	writeFileMust(path, getBeforeContentMust(change.Path))
 }
 
 func copyFileModifiedMust(dirBefore, dirAfter string, change *GitChange) {
-	// current version on disk in after
 	path := filepath.Join(dirAfter, change.Name)
-	copyFileMust(path, change.Path)
-	// version from HEAD in before
+	writeFileMust(path, getAfterContentMust(change.Path))
 	path = filepath.Join(dirBefore, change.Name)
-	catGitHeadToFileMust(path, change.Path)
+	writeFileMust(path, getBeforeContentMust(change.Path))
+}
+
+func copyFileRenamedMust(dirBefore, dirAfter string, change *GitChange) {
+	// after-rev content at the new path in after, before-rev content at the old path in before
+	path := filepath.Join(dirAfter, change.Name)
+	writeFileMust(path, getAfterContentMust(change.Path))
+	path = filepath.Join(dirBefore, change.Name)
+	writeFileMust(path, getBeforeContentMust(change.OldPath))
+}
+
+// getConflictDirs lays out the three stages of a merge conflict (see
+// git-merge(1)): base is the common ancestor (stage 1), local/ours is
+// stage 2, remote/theirs is stage 3.
+func getConflictDirs(dir, name string) (base, local, remote string) {
+	base = filepath.Join(dir, "conflicts", name, "base")
+	local = filepath.Join(dir, "conflicts", name, "local")
+	remote = filepath.Join(dir, "conflicts", name, "remote")
+	return
+}
+
+// gitShowStageMust returns the content of path at merge stage 1/2/3 from the
+// index, or ok == false if that stage wasn't recorded (e.g. one side deleted
+// the file). This always goes through the git binary: unmerged index stages
+// aren't something the goGitBackend abstraction models today.
+func gitShowStageMust(stage int, path string) ([]byte, bool) {
+	out, err := runCmd(gitPath, "show", fmt.Sprintf(":%d:%s", stage, path))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func copyFileUnmergedMust(dir string, change *GitChange) {
+	base, local, remote := getConflictDirs(dir, change.Name)
+	for stage, outDir := range map[int]string{1: base, 2: local, 3: remote} {
+		fataliferr(os.MkdirAll(outDir, 0755))
+		path := filepath.Join(outDir, change.Name)
+		d, ok := gitShowStageMust(stage, change.Path)
+		if !ok {
+			createEmptyFileMust(path)
+			continue
+		}
+		writeFileMust(path, d)
+	}
 }
 
 func copyFileChangeMust(dir string, change *GitChange) {
 	dirBefore, dirAfter := getBeforeAfterDirs(dir)
-	switch change.Type {
-	case Added:
+	switch {
+	case change.Unmerged:
+		copyFileUnmergedMust(dir, change)
+	case change.Renamed || change.Copied:
+		copyFileRenamedMust(dirBefore, dirAfter, change)
+	case change.Type == Added:
 		copyFileAddedMust(dirBefore, dirAfter, change)
-	case Modified:
-		copyFileModifiedMust(dirBefore, dirAfter, change)
-	case Deleted:
+	case change.Type == Modified:
 		copyFileModifiedMust(dirBefore, dirAfter, change)
+	case change.Type == Deleted:
+		copyFileDeletedMust(dirBefore, dirAfter, change)
 	default:
 		fatalif(true, "unknown change %+v\n", change)
 	}
 }
 
+// gitBlobSHA1OfFileMust computes git's blob object hash for the file at
+// path: sha1("blob " + len + "\x00" + content), streamed without loading the
+// whole file into memory.
+func gitBlobSHA1OfFileMust(path string) string {
+	fi, err := os.Stat(path)
+	fataliferr(err)
+	f, err := os.Open(path)
+	fataliferr(err)
+	defer f.Close()
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", fi.Size())
+	_, err = io.Copy(h, f)
+	fataliferr(err)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// headBlobSHA1 returns the blob SHA `git ls-tree HEAD` reports for path,
+// without fetching its content, or "" if it can't be determined (no git
+// binary, or path isn't tracked at HEAD). Deliberately not `git ls-files -s`,
+// which reports the index (staging area) entry rather than HEAD — for a
+// file that's been `git add`ed with no further edits those differ, and
+// comparing against the index would skip showing staged changes.
+func headBlobSHA1(path string) string {
+	if gitPath == "" {
+		return ""
+	}
+	out, err := runCmd(gitPath, "ls-tree", "HEAD", "--", path)
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 {
+		return ""
+	}
+	return fields[2]
+}
+
+// isUnchangedMust reports whether change can be skipped entirely: a plain
+// worktree-vs-HEAD Modified entry whose content hasn't actually changed
+// (common after a revert or a no-op touch). Cheap hash comparisons avoid
+// fetching the HEAD blob just to find out it's identical.
+func isUnchangedMust(change *GitChange) bool {
+	if change.Type != Modified || change.Renamed || change.Copied || change.Unmerged {
+		return false
+	}
+	if diffFromRev != "" || diffToRev != "" {
+		// not the default worktree-vs-HEAD comparison; always materialize
+		return false
+	}
+	headSHA := headBlobSHA1(change.Path)
+	if headSHA == "" {
+		return false
+	}
+	return gitBlobSHA1OfFileMust(change.Path) == headSHA
+}
+
 func copyFiles(dir string, changes []*GitChange) {
 	dirBefore, dirAfter := getBeforeAfterDirs(dir)
 	err := os.MkdirAll(dirBefore, 0755)
 	fataliferr(err)
 	err = os.MkdirAll(dirAfter, 0755)
 	fataliferr(err)
+
+	var g errgroup.Group
+	g.SetLimit(runtime.NumCPU())
 	for _, change := range changes {
-		copyFileChangeMust(dir, change)
+		change := change
+		g.Go(func() error {
+			if isUnchangedMust(change) {
+				fmt.Printf("skipping unchanged file: %s\n", change.Path)
+				return nil
+			}
+			copyFileChangeMust(dir, change)
+			return nil
+		})
 	}
+	fataliferr(g.Wait())
 }
 
-func hasGitDirMust(dir string) bool {
-	files, err := ioutil.ReadDir(dir)
+// resolveGitDirMust looks for ".git" in dir and resolves it to the actual
+// git directory. ".git" is usually a directory, but inside a linked worktree
+// (created by "git worktree add") or a submodule it's a regular file
+// containing a "gitdir: <path>" pointer (see gitrepository-layout(5)); in
+// that case the pointer is resolved relative to dir.
+func resolveGitDirMust(dir string) (string, bool) {
+	path := filepath.Join(dir, ".git")
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	if fi.IsDir() {
+		return path, true
+	}
+	d, err := ioutil.ReadFile(path)
 	fataliferr(err)
-	for _, fi := range files {
-		if strings.ToLower(fi.Name()) == ".git" {
-			return fi.IsDir()
-		}
+	s := strings.TrimSpace(string(d))
+	const prefix = "gitdir: "
+	if !strings.HasPrefix(s, prefix) {
+		return "", false
 	}
-	return false
+	gitDir := strings.TrimSpace(s[len(prefix):])
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return filepath.Clean(gitDir), true
+}
+
+func hasGitDirMust(dir string) bool {
+	_, ok := resolveGitDirMust(dir)
+	return ok
 }
 
 // git status returns names relative to root of
@@ -305,7 +872,8 @@ func cdToGitRoot() {
 	dir, err := os.Getwd()
 	fataliferr(err)
 	for {
-		if hasGitDirMust(dir) {
+		if gitDir, ok := resolveGitDirMust(dir); ok {
+			gitDirPath = gitDir
 			break
 		}
 		newDir = filepath.Dir(dir)
@@ -318,14 +886,55 @@ func cdToGitRoot() {
 	}
 }
 
+// splitRevRangeMust splits a "--range FROM..TO" argument.
+func splitRevRangeMust(s string) (string, string) {
+	parts := strings.SplitN(s, "..", 2)
+	fatalif(len(parts) != 2 || parts[0] == "" || parts[1] == "", "invalid --range '%s', want FROM..TO\n", s)
+	return parts[0], parts[1]
+}
+
+// changesToPreviewMust figures out, from --staged/--range/--commit, which
+// changes to preview and configures diffFromRev/diffToRev to match, falling
+// back to the default worktree-vs-HEAD comparison.
+func changesToPreviewMust() []*GitChange {
+	set := 0
+	for _, b := range []bool{*stagedFlag, *rangeFlag != "", *commitFlag != ""} {
+		if b {
+			set++
+		}
+	}
+	fatalif(set > 1, "--staged, --range and --commit are mutually exclusive\n")
+
+	switch {
+	case *stagedFlag:
+		diffFromRev, diffToRev = "HEAD", indexRev
+		return gitDiffTreeMust("HEAD", indexRev)
+	case *rangeFlag != "":
+		from, to := splitRevRangeMust(*rangeFlag)
+		diffFromRev, diffToRev = from, to
+		return gitDiffTreeMust(from, to)
+	case *commitFlag != "":
+		diffFromRev, diffToRev = *commitFlag+"^", *commitFlag
+		return gitDiffTreeMust(diffFromRev, diffToRev)
+	default:
+		return gitStatusMust()
+	}
+}
+
 func main() {
+	flag.Parse()
 	detectExesMust()
 	createTempDirMust()
 	fmt.Printf("temp dir: %s\n", tempDir)
 	deleteOldDirs()
 
 	cdToGitRoot()
-	changes := gitStatusMust()
+	worktreeRoot, err := os.Getwd()
+	fataliferr(err)
+	backend = newGitBackend(*backendFlag, worktreeRoot, gitDirPath)
+	fmt.Printf("using git backend: %s\n", backend.Name())
+
+	changes := changesToPreviewMust()
 	if len(changes) == 0 {
 		fmt.Printf("No changes to preview!")
 		os.Exit(0)
@@ -335,8 +944,18 @@ func main() {
 	// TODO: verify GitChange.Name is unique in changes
 	subDir := time.Now().Format("2006-01-02_15_04_05")
 	dir := filepath.Join(tempDir, subDir)
-	err := os.MkdirAll(dir, 0755)
+	err = os.MkdirAll(dir, 0755)
 	fataliferr(err)
 	copyFiles(dir, changes)
-	runWinMerge(dir)
+
+	if *webFlag {
+		runWebUIMust(dir, changes)
+		return
+	}
+
+	tool := pickDiffToolMust(*toolFlag)
+	fmt.Printf("using diff tool: %s\n", tool.Name())
+	dirBefore, dirAfter := getBeforeAfterDirs(dir)
+	err = tool.Compare(dirBefore, dirAfter)
+	fataliferr(err)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveGitDirMust(t *testing.T) {
+	t.Run("plain directory", func(t *testing.T) {
+		dir := t.TempDir()
+		mustMkdir(t, filepath.Join(dir, ".git"))
+
+		got, ok := resolveGitDirMust(dir)
+		want := filepath.Join(dir, ".git")
+		if !ok || got != want {
+			t.Errorf("resolveGitDirMust(%q) = %q, %v; want %q, true", dir, got, ok, want)
+		}
+	})
+
+	// A linked worktree (`git worktree add`) has ".git" as a file containing
+	// "gitdir: <path>", pointing at the main repo's
+	// ".git/worktrees/<name>" directory (see gitrepository-layout(5)).
+	t.Run("linked worktree pointer file", func(t *testing.T) {
+		mainGitDir := filepath.Join(t.TempDir(), ".git", "worktrees", "feature")
+		mustMkdir(t, mainGitDir)
+
+		wtDir := t.TempDir()
+		pointer := "gitdir: " + mainGitDir + "\n"
+		mustWriteFile(t, filepath.Join(wtDir, ".git"), pointer)
+
+		got, ok := resolveGitDirMust(wtDir)
+		if !ok || got != mainGitDir {
+			t.Errorf("resolveGitDirMust(%q) = %q, %v; want %q, true", wtDir, got, ok, mainGitDir)
+		}
+	})
+
+	t.Run("relative pointer", func(t *testing.T) {
+		root := t.TempDir()
+		mainGitDir := filepath.Join(root, ".git", "worktrees", "feature")
+		mustMkdir(t, mainGitDir)
+
+		wtDir := filepath.Join(root, "wt")
+		mustMkdir(t, wtDir)
+		mustWriteFile(t, filepath.Join(wtDir, ".git"), "gitdir: .git/worktrees/feature\n")
+
+		got, ok := resolveGitDirMust(wtDir)
+		want := filepath.Clean(filepath.Join(wtDir, ".git/worktrees/feature"))
+		if !ok || got != want {
+			t.Errorf("resolveGitDirMust(%q) = %q, %v; want %q, true", wtDir, got, ok, want)
+		}
+	})
+
+	t.Run("no .git", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, ok := resolveGitDirMust(dir); ok {
+			t.Errorf("resolveGitDirMust(%q) = _, true; want false", dir)
+		}
+	})
+}
+
+func mustMkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustWriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DiffTool launches an external tool to compare two directory trees.
+// Built-in implementations cover the common cross-platform tools; genericTool
+// falls back to whatever `git difftool` would launch for a given name.
+type DiffTool interface {
+	Name() string
+	// Detect reports whether the tool is available and, if so, its path.
+	Detect() (path string, ok bool)
+	Compare(dirBefore, dirAfter string) error
+}
+
+// diffTools is the set of built-in tools, tried in this order when nothing
+// else picks a winner.
+var diffTools = []DiffTool{
+	&winMergeTool{},
+	&meldTool{},
+	&kdiff3Tool{},
+	&beyondCompareTool{},
+	&vscodeTool{},
+}
+
+// pickDiffToolMust selects a DiffTool. Selection order: the --tool flag, the
+// SUMATRA_DIFFTOOL env var, `git config diff.tool`, then the first built-in
+// tool found on PATH.
+func pickDiffToolMust(toolFlag string) DiffTool {
+	if toolFlag != "" {
+		return resolveDiffToolMust(toolFlag)
+	}
+	if name := os.Getenv("SUMATRA_DIFFTOOL"); name != "" {
+		return resolveDiffToolMust(name)
+	}
+	if name := gitConfigGet("diff.tool"); name != "" {
+		if t := resolveDiffTool(name); t != nil {
+			return t
+		}
+	}
+	for _, t := range diffTools {
+		if _, ok := t.Detect(); ok {
+			return t
+		}
+	}
+	fatalf("no diff tool found; install one of WinMerge/Meld/kdiff3/Beyond Compare/VS Code or set SUMATRA_DIFFTOOL\n")
+	return nil
+}
+
+// resolveDiffTool resolves name against the built-in tools first, falling
+// back to a git-config-driven tool (difftool.<name>.cmd). It returns nil if
+// neither matches.
+func resolveDiffTool(name string) DiffTool {
+	for _, t := range diffTools {
+		if strings.EqualFold(t.Name(), name) {
+			return t
+		}
+	}
+	if t := newGitConfigTool(name); t != nil {
+		return t
+	}
+	return nil
+}
+
+func resolveDiffToolMust(name string) DiffTool {
+	t := resolveDiffTool(name)
+	fatalif(t == nil, "unknown diff tool '%s' (not built-in and no difftool.%s.cmd in git config)\n", name, name)
+	return t
+}
+
+// gitConfigGet reads a git config key, returning "" if it's unset or git
+// itself isn't available.
+func gitConfigGet(key string) string {
+	out, err := exec.Command("git", "config", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// http://manual.winmerge.org/Command_line.html
+type winMergeTool struct{}
+
+func (t *winMergeTool) Name() string { return "winmerge" }
+
+func (t *winMergeTool) Detect() (string, bool) {
+	path, err := exec.LookPath("WinMergeU")
+	return path, err == nil
+}
+
+func (t *winMergeTool) Compare(dirBefore, dirAfter string) error {
+	path, ok := t.Detect()
+	fatalif(!ok, "WinMergeU not found on PATH\n")
+	/*
+		/u : don't add paths to MRU
+		/wl, /wr : open left/right as read-only
+	*/
+	_, err := runCmd(path, "/u", "/wl", "/wr", dirBefore, dirAfter)
+	return err
+}
+
+type meldTool struct{}
+
+func (t *meldTool) Name() string { return "meld" }
+
+func (t *meldTool) Detect() (string, bool) {
+	path, err := exec.LookPath("meld")
+	return path, err == nil
+}
+
+func (t *meldTool) Compare(dirBefore, dirAfter string) error {
+	path, ok := t.Detect()
+	fatalif(!ok, "meld not found on PATH\n")
+	_, err := runCmd(path, dirBefore, dirAfter)
+	return err
+}
+
+type kdiff3Tool struct{}
+
+func (t *kdiff3Tool) Name() string { return "kdiff3" }
+
+func (t *kdiff3Tool) Detect() (string, bool) {
+	path, err := exec.LookPath("kdiff3")
+	return path, err == nil
+}
+
+func (t *kdiff3Tool) Compare(dirBefore, dirAfter string) error {
+	path, ok := t.Detect()
+	fatalif(!ok, "kdiff3 not found on PATH\n")
+	_, err := runCmd(path, dirBefore, dirAfter)
+	return err
+}
+
+type beyondCompareTool struct{}
+
+func (t *beyondCompareTool) Name() string { return "bcompare" }
+
+func (t *beyondCompareTool) Detect() (string, bool) {
+	path, err := exec.LookPath("bcompare")
+	return path, err == nil
+}
+
+func (t *beyondCompareTool) Compare(dirBefore, dirAfter string) error {
+	path, ok := t.Detect()
+	fatalif(!ok, "bcompare not found on PATH\n")
+	_, err := runCmd(path, dirBefore, dirAfter)
+	return err
+}
+
+type vscodeTool struct{}
+
+func (t *vscodeTool) Name() string { return "code" }
+
+func (t *vscodeTool) Detect() (string, bool) {
+	path, err := exec.LookPath("code")
+	return path, err == nil
+}
+
+func (t *vscodeTool) Compare(dirBefore, dirAfter string) error {
+	path, ok := t.Detect()
+	fatalif(!ok, "code not found on PATH\n")
+	_, err := runCmd(path, "--diff", dirBefore, dirAfter)
+	return err
+}
+
+// gitConfigTool runs whatever command `git difftool` would launch for a
+// non-built-in tool name, per difftool.<name>.cmd in git config.
+type gitConfigTool struct {
+	toolName string
+	cmd      string
+}
+
+func newGitConfigTool(name string) *gitConfigTool {
+	cmd := gitConfigGet("difftool." + name + ".cmd")
+	if cmd == "" {
+		return nil
+	}
+	return &gitConfigTool{toolName: name, cmd: cmd}
+}
+
+func (t *gitConfigTool) Name() string { return t.toolName }
+
+func (t *gitConfigTool) Detect() (string, bool) {
+	return t.cmd, t.cmd != ""
+}
+
+func (t *gitConfigTool) Compare(dirBefore, dirAfter string) error {
+	cmd := strings.NewReplacer("$LOCAL", dirBefore, "$REMOTE", dirAfter).Replace(t.cmd)
+	fmt.Printf("running: %s\n", cmd)
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// webContextLines is how many unchanged lines to show around a change before
+// collapsing the rest behind an "expand context" control.
+const webContextLines = 3
+
+// runWebUIMust starts a localhost http server rendering changes as an
+// alternative to launching an external DiffTool, reusing the same
+// before/after directories copyFiles already laid out.
+func runWebUIMust(dir string, changes []*GitChange) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", webIndexHandler(dir, changes))
+	mux.HandleFunc("/diff/", webDiffHandler(dir, changes))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	fataliferr(err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	url := fmt.Sprintf("http://127.0.0.1:%d/", port)
+	fmt.Printf("web UI at %s (Ctrl+C to stop)\n", url)
+	openBrowser(url)
+	fataliferr(http.Serve(ln, mux))
+}
+
+// openBrowser launches the user's default browser on url.
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("couldn't launch a browser automatically (%s); open %s yourself\n", err, url)
+	}
+}
+
+var webIndexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>sum-diff-preview</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+li { margin: 0.3em 0; }
+</style>
+</head><body>
+<h1>{{len .}} change(s)</h1>
+<ul>
+{{range $i, $c := .}}<li><a href="/diff/{{$i}}">{{$c.Path}}</a>{{if $c.Renamed}} (renamed from {{$c.OldPath}}){{end}}</li>
+{{end}}
+</ul>
+</body></html>`))
+
+func webIndexHandler(dir string, changes []*GitChange) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fataliferr(webIndexTmpl.Execute(w, changes))
+	}
+}
+
+var webDiffTmpl = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Change.Path}}</title>
+<style>
+body { font-family: monospace; margin: 0; }
+header { font-family: sans-serif; padding: 0.5em 1em; background: #eee; position: sticky; top: 0; }
+.cols { display: flex; }
+.col { width: 50%; white-space: pre-wrap; word-break: break-all; padding: 0.5em; box-sizing: border-box; }
+.col.left { border-right: 1px solid #ccc; }
+.del { background: #fdd; }
+.ins { background: #dfd; }
+.ctx-toggle { color: #06c; cursor: pointer; background: #eef; display: block; }
+.ctx-hidden { display: none; }
+</style>
+</head><body>
+<header>
+{{.Change.Path}} ({{.Index}} of {{.Total}}) -
+<a id="prev" href="{{if gt .Index 0}}/diff/{{.Prev}}{{else}}#{{end}}">&larr; prev</a>
+<a id="next" href="{{if .HasNext}}/diff/{{.Next}}{{else}}#{{end}}">next &rarr;</a>
+<a href="/">all files</a>
+</header>
+<div class="cols">
+<div class="col left">{{.Left}}</div>
+<div class="col right">{{.Right}}</div>
+</div>
+<script>
+document.addEventListener('click', function(e) {
+	if (e.target.classList.contains('ctx-toggle')) {
+		var id = e.target.getAttribute('data-target');
+		document.querySelectorAll('.' + id).forEach(function(el) {
+			el.classList.toggle('ctx-hidden');
+		});
+		e.target.classList.toggle('ctx-hidden-marker');
+	}
+});
+document.addEventListener('keydown', function(e) {
+	if (e.key === 'ArrowLeft') document.getElementById('prev').click();
+	if (e.key === 'ArrowRight') document.getElementById('next').click();
+});
+</script>
+</body></html>`))
+
+type webDiffPage struct {
+	Change      *GitChange
+	Index       int
+	Total       int
+	Prev, Next  int
+	HasNext     bool
+	Left, Right template.HTML
+}
+
+func webDiffHandler(dir string, changes []*GitChange) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idxStr := strings.TrimPrefix(r.URL.Path, "/diff/")
+		idx, err := strconv.Atoi(idxStr)
+		if err != nil || idx < 0 || idx >= len(changes) {
+			http.NotFound(w, r)
+			return
+		}
+		change := changes[idx]
+		dirBefore, dirAfter := getBeforeAfterDirs(dir)
+		before := readFileOrEmpty(filepath.Join(dirBefore, change.Name))
+		after := readFileOrEmpty(filepath.Join(dirAfter, change.Name))
+		left, right := computeSideBySideDiffHTML(before, after)
+		page := webDiffPage{
+			Change:  change,
+			Index:   idx,
+			Total:   len(changes),
+			Prev:    idx - 1,
+			Next:    idx + 1,
+			HasNext: idx+1 < len(changes),
+			Left:    left,
+			Right:   right,
+		}
+		fataliferr(webDiffTmpl.Execute(w, page))
+	}
+}
+
+func readFileOrEmpty(path string) string {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(d)
+}
+
+// computeSideBySideDiffHTML renders before/after as two HTML columns,
+// highlighting removed lines on the left and added lines on the right, with
+// long unchanged runs collapsed behind a "... N more unchanged lines, click
+// to expand ..." toggle.
+func computeSideBySideDiffHTML(before, after string) (template.HTML, template.HTML) {
+	dmp := diffmatchpatch.New()
+	a, b, lineArray := dmp.DiffLinesToChars(before, after)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lineArray)
+
+	var left, right strings.Builder
+	ctxID := 0
+	for _, d := range diffs {
+		lines := splitDiffLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			writeEqualLines(&left, &right, lines, &ctxID)
+		case diffmatchpatch.DiffDelete:
+			for _, l := range lines {
+				left.WriteString("<span class=\"del\">" + template.HTMLEscapeString(l) + "</span>\n")
+			}
+		case diffmatchpatch.DiffInsert:
+			for _, l := range lines {
+				right.WriteString("<span class=\"ins\">" + template.HTMLEscapeString(l) + "</span>\n")
+			}
+		}
+	}
+	return template.HTML(left.String()), template.HTML(right.String())
+}
+
+func splitDiffLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// writeEqualLines appends an unchanged block to both columns, collapsing the
+// middle behind a toggle when the block is longer than 2*webContextLines+1.
+func writeEqualLines(left, right *strings.Builder, lines []string, ctxID *int) {
+	n := len(lines)
+	if n <= 2*webContextLines+1 {
+		for _, l := range lines {
+			esc := template.HTMLEscapeString(l) + "\n"
+			left.WriteString(esc)
+			right.WriteString(esc)
+		}
+		return
+	}
+	*ctxID++
+	class := fmt.Sprintf("ctx%d", *ctxID)
+	for _, l := range lines[:webContextLines] {
+		esc := template.HTMLEscapeString(l) + "\n"
+		left.WriteString(esc)
+		right.WriteString(esc)
+	}
+	toggle := fmt.Sprintf("<span class=\"ctx-toggle\" data-target=\"%s\">... %d more unchanged lines, click to expand ...</span>\n", class, n-2*webContextLines)
+	left.WriteString(toggle)
+	right.WriteString(toggle)
+	for _, l := range lines[webContextLines : n-webContextLines] {
+		esc := fmt.Sprintf("<span class=\"%s ctx-hidden\">%s</span>\n", class, template.HTMLEscapeString(l))
+		left.WriteString(esc)
+		right.WriteString(esc)
+	}
+	for _, l := range lines[n-webContextLines:] {
+		esc := template.HTMLEscapeString(l) + "\n"
+		left.WriteString(esc)
+		right.WriteString(esc)
+	}
+}